@@ -0,0 +1,218 @@
+package node
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Goplush/lachesisnode/m/net"
+)
+
+const (
+	//blockDelayTimeout bounds how long a cached announcement is trusted
+	//before it is considered stale and a real SyncRequest is used instead.
+	blockDelayTimeout = 10 * time.Second
+
+	//maxNodeCount bounds how many peer announcements we keep cached at
+	//once, mirroring the light-fetcher tree-node idea of a bounded cache.
+	maxNodeCount = 20
+)
+
+//fetcherPeerInfo caches the last heads a peer announced to us, so that
+//lachesis() can skip a SyncRequest when we already know the peer has
+//nothing new for us.
+type fetcherPeerInfo struct {
+	heads     map[int]int //participant id -> latest known index
+	knownHash string
+	announced time.Time
+}
+
+//announceCache tracks the most recently announced heads of each peer.
+type announceCache struct {
+	lock  sync.Mutex
+	peers map[string]*fetcherPeerInfo
+}
+
+func newAnnounceCache() *announceCache {
+	return &announceCache{
+		peers: make(map[string]*fetcherPeerInfo),
+	}
+}
+
+//record stores a peer's freshly announced heads, evicting the oldest
+//entry first if the cache is already at maxNodeCount.
+func (ac *announceCache) record(peerAddr string, heads map[int]int, knownHash string) {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+
+	if _, exists := ac.peers[peerAddr]; !exists && len(ac.peers) >= maxNodeCount {
+		ac.evictOldestLocked()
+	}
+
+	ac.peers[peerAddr] = &fetcherPeerInfo{
+		heads:     heads,
+		knownHash: knownHash,
+		announced: time.Now(),
+	}
+}
+
+func (ac *announceCache) evictOldestLocked() {
+	var oldestAddr string
+	var oldestTime time.Time
+	for addr, info := range ac.peers {
+		if oldestAddr == "" || info.announced.Before(oldestTime) {
+			oldestAddr = addr
+			oldestTime = info.announced
+		}
+	}
+	if oldestAddr != "" {
+		delete(ac.peers, oldestAddr)
+	}
+}
+
+//get returns the cached heads and known-set hash for a peer, if any, and
+//whether they are still fresh (younger than blockDelayTimeout).
+func (ac *announceCache) get(peerAddr string) (heads map[int]int, knownHash string, fresh bool) {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+
+	info, ok := ac.peers[peerAddr]
+	if !ok {
+		return nil, "", false
+	}
+	if time.Since(info.announced) > blockDelayTimeout {
+		delete(ac.peers, peerAddr)
+		return nil, "", false
+	}
+	return info.heads, info.knownHash, true
+}
+
+//upToDate reports whether the peer's cached heads are all known to us
+//already, meaning a SyncRequest would come back empty.
+func upToDate(peerHeads, ourKnown map[int]int) bool {
+	for participant, index := range peerHeads {
+		if ourKnown[participant] < index {
+			return false
+		}
+	}
+	return true
+}
+
+//hashKnown produces a cheap hash of a KnownEvents map. maybeGossip compares
+//it against the peer's own known-set hash so a mutual no-op can be detected
+//without comparing the two maps entry by entry, even though the full map is still shipped
+//alongside it for the (more common) partial-overlap case.
+func hashKnown(known map[int]int) string {
+	participants := make([]int, 0, len(known))
+	for p := range known {
+		participants = append(participants, p)
+	}
+	sort.Ints(participants)
+
+	h := fnv.New64a()
+	for _, p := range participants {
+		h.Write([]byte(strconv.Itoa(p)))
+		h.Write([]byte{':'})
+		h.Write([]byte(strconv.Itoa(known[p])))
+		h.Write([]byte{';'})
+	}
+
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+func (n *Node) processAnnounceRequest(rpc net.RPC, cmd *net.AnnounceRequest) {
+	n.logger.WithFields(logrus.Fields{
+		"from_id": cmd.FromID,
+		"heads":   cmd.HeadsPerParticipant,
+	}).Debug("process AnnounceRequest")
+
+	n.coreLock.Lock()
+	knownEvents := n.core.KnownEvents()
+	n.coreLock.Unlock()
+
+	resp := &net.AnnounceResponse{
+		FromID:              n.id,
+		HeadsPerParticipant: knownEvents,
+		KnownEventsHash:     hashKnown(knownEvents),
+	}
+
+	rpc.Respond(resp, nil)
+}
+
+//announce pushes our own current heads to a peer, right after we create a
+//new self-event (see broadcastAnnounce), and records the heads the peer
+//replies with in our announce cache.
+func (n *Node) announce(peerAddr string) error {
+	n.coreLock.Lock()
+	knownEvents := n.core.KnownEvents()
+	n.coreLock.Unlock()
+
+	args := net.AnnounceRequest{
+		FromID:              n.id,
+		KnownEventsHash:     hashKnown(knownEvents),
+		HeadsPerParticipant: knownEvents,
+	}
+
+	var out net.AnnounceResponse
+	if err := n.trans.Announce(peerAddr, &args, &out); err != nil {
+		return fmt.Errorf("announcing to %s: %s", peerAddr, err)
+	}
+
+	n.announceCache.record(peerAddr, out.HeadsPerParticipant, out.KnownEventsHash)
+
+	return nil
+}
+
+//maybeGossip consults the announce cache before gossiping with peer: if
+//the peer's cached known-set hash matches ours exactly, we already know
+//it's a mutual no-op without comparing the maps entry by entry. Failing
+//that, if the
+//peer already announced heads that cover everything we know, a full
+//SyncRequest would come back empty, so it is skipped in favor of a push
+//(only if we have something new for them) or nothing at all.
+func (n *Node) maybeGossip(peer net.Peer) {
+	n.coreLock.Lock()
+	ourKnown := n.core.KnownEvents()
+	n.coreLock.Unlock()
+
+	heads, knownHash, fresh := n.announceCache.get(peer.NetAddr)
+	if !fresh {
+		n.gossip(peer.NetAddr)
+		return
+	}
+
+	if knownHash == hashKnown(ourKnown) {
+		n.logger.WithField("peer", peer.NetAddr).Debug("Skipping SyncRequest: known-set hash matches announce cache")
+		return
+	}
+
+	if upToDate(heads, ourKnown) {
+		n.logger.WithField("peer", peer.NetAddr).Debug("Skipping SyncRequest: up to date per announce cache")
+		if !upToDate(ourKnown, heads) {
+			n.push(peer.NetAddr, heads)
+		}
+		return
+	}
+
+	n.gossip(peer.NetAddr)
+}
+
+//broadcastAnnounce pushes our current heads to every peer, best-effort.
+//Each reply refreshes our announce cache entry for that peer, so our own
+//idle ticks can skip a SyncRequest to it; the receiving peer answers with
+//its heads and does not cache ours.
+func (n *Node) broadcastAnnounce() {
+	for _, p := range n.peerSelector.Peers() {
+		peerAddr := p.NetAddr
+		n.goFunc(func() {
+			if err := n.announce(peerAddr); err != nil {
+				n.logger.WithField("error", err).Debug("broadcastAnnounce")
+			}
+		})
+	}
+}
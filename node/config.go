@@ -0,0 +1,52 @@
+package node
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultMaxConsecutiveFailures = 5
+	DefaultQuarantineBaseBackoff  = 1 * time.Second
+	DefaultMaxQuarantineBackoff   = 2 * time.Minute
+)
+
+//Config holds the parameters that govern how a Node behaves: how often
+//it gossips, how far behind a peer can be before triggering SyncLimit,
+//and how tolerant it is of peers that keep failing.
+type Config struct {
+	//HeartbeatTimeout is the frequency of the gossip timer, when the
+	//node has something to gossip about.
+	HeartbeatTimeout time.Duration
+
+	//SyncLimit is the max number of events a node will accept in a
+	//single SyncResponse/EagerSyncRequest before it switches to
+	//CatchingUp.
+	SyncLimit int
+
+	//MaxConsecutiveFailures is how many requestSync/requestEagerSync
+	//failures in a row a peer can accumulate before being quarantined.
+	MaxConsecutiveFailures int
+
+	//QuarantineBaseBackoff and MaxQuarantineBackoff bound the
+	//exponential backoff applied once a peer is quarantined:
+	//base * 2^(failures-MaxConsecutiveFailures), capped at MaxQuarantineBackoff.
+	QuarantineBaseBackoff time.Duration
+	MaxQuarantineBackoff  time.Duration
+
+	Logger *logrus.Entry
+}
+
+//NewConfig returns a Config with the given heartbeat and sync limit, and
+//default quarantine thresholds.
+func NewConfig(heartbeatTimeout time.Duration, syncLimit int, logger *logrus.Entry) *Config {
+	return &Config{
+		HeartbeatTimeout:       heartbeatTimeout,
+		SyncLimit:              syncLimit,
+		MaxConsecutiveFailures: DefaultMaxConsecutiveFailures,
+		QuarantineBaseBackoff:  DefaultQuarantineBaseBackoff,
+		MaxQuarantineBackoff:   DefaultMaxQuarantineBackoff,
+		Logger:                 logger,
+	}
+}
@@ -0,0 +1,170 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxPendingTxs = 10000
+	defaultMaxTxBytes    = 10 * 1024 * 1024 //total bytes held across pending txs
+	defaultSenderRate    = 50               //txs per sender per SenderWindow
+	defaultSenderWindow  = 1 * time.Second
+
+	//defaultSenderTTL bounds how long an idle sender's rate-limit entry
+	//is kept around; senders untouched for longer are swept from the
+	//map so it can't grow without bound over the life of the process.
+	defaultSenderTTL = 10 * defaultSenderWindow
+
+	//senderPrefixLen assumes the first N bytes of a transaction identify
+	//its sender (as is the case for most account-based payloads); it is
+	//only used to bucket the rate limiter, never for validation.
+	senderPrefixLen = 20
+)
+
+//ErrMempoolFull is returned by addTransaction when the mempool is at
+//capacity (by count or by bytes) and cannot accept a new transaction.
+var ErrMempoolFull = fmt.Errorf("mempool full")
+
+//ErrSenderRateLimited is returned when a single sender submits
+//transactions faster than its allotted rate.
+var ErrSenderRateLimited = fmt.Errorf("sender rate limited")
+
+//txChecker is implemented by proxy.AppProxy implementations that want to
+//validate a transaction (signature, nonce, etc.) before it enters the
+//mempool. Invalid transactions are dropped immediately instead of being
+//gossiped and only rejected later at CommitBlock.
+type txChecker interface {
+	CheckTx(tx []byte) error
+}
+
+//mempoolFullReporter is implemented by proxy.AppProxy implementations
+//that want to be told when a submitted transaction was refused, so the
+//app can apply its own back-pressure instead of resubmitting blindly.
+type mempoolFullReporter interface {
+	SubmitError(tx []byte, err error)
+}
+
+type senderWindow struct {
+	count int
+	since time.Time
+}
+
+//txMempool bounds the number and total size of transactions waiting to
+//enter core.transactionPool, and enforces a simple per-sender rate
+//limit, so a misbehaving app can't grow the pool without bound.
+type txMempool struct {
+	MaxPendingTxs int
+	MaxTxBytes    int
+	SenderRate    int
+	SenderWindow  time.Duration
+
+	//SenderTTL bounds how long a sender's rate-limit entry survives
+	//without activity before sweepStaleSenders reclaims it.
+	SenderTTL time.Duration
+
+	lock         sync.Mutex
+	pendingTxs   int
+	pendingBytes int
+	senders      map[string]*senderWindow
+	lastSwept    time.Time
+
+	rejected int
+	evicted  int
+}
+
+func newTxMempool() *txMempool {
+	return &txMempool{
+		MaxPendingTxs: defaultMaxPendingTxs,
+		MaxTxBytes:    defaultMaxTxBytes,
+		SenderRate:    defaultSenderRate,
+		SenderWindow:  defaultSenderWindow,
+		SenderTTL:     defaultSenderTTL,
+		senders:       make(map[string]*senderWindow),
+	}
+}
+
+func senderKey(tx []byte) string {
+	n := senderPrefixLen
+	if len(tx) < n {
+		n = len(tx)
+	}
+	sum := sha256.Sum256(tx[:n])
+	return hex.EncodeToString(sum[:8])
+}
+
+//tryAdd admits tx into the mempool's accounting, or returns an error if
+//doing so would exceed MaxPendingTxs, MaxTxBytes, or the sender's rate
+//limit. The caller is responsible for actually queuing tx afterwards.
+func (mp *txMempool) tryAdd(tx []byte) error {
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+
+	if mp.pendingTxs >= mp.MaxPendingTxs || mp.pendingBytes+len(tx) > mp.MaxTxBytes {
+		mp.rejected++
+		return ErrMempoolFull
+	}
+
+	now := time.Now()
+	mp.sweepStaleSenders(now)
+
+	key := senderKey(tx)
+	w, ok := mp.senders[key]
+	if !ok || now.Sub(w.since) > mp.SenderWindow {
+		w = &senderWindow{since: now}
+		mp.senders[key] = w
+	}
+	if w.count >= mp.SenderRate {
+		mp.rejected++
+		return ErrSenderRateLimited
+	}
+	w.count++
+
+	mp.pendingTxs++
+	mp.pendingBytes += len(tx)
+
+	return nil
+}
+
+//sweepStaleSenders reclaims senderWindow entries that have sat idle past
+//SenderTTL, so mp.senders can't grow without bound over the life of the
+//process (e.g. from many distinct one-off senders). Runs at most once per
+//SenderWindow, and must be called with mp.lock held.
+func (mp *txMempool) sweepStaleSenders(now time.Time) {
+	if now.Sub(mp.lastSwept) < mp.SenderWindow {
+		return
+	}
+	mp.lastSwept = now
+
+	for key, w := range mp.senders {
+		if now.Sub(w.since) > mp.SenderTTL {
+			delete(mp.senders, key)
+		}
+	}
+}
+
+//recordEvicted counts a transaction that was dropped before ever
+//entering the mempool, e.g. because CheckTx rejected it as invalid.
+func (mp *txMempool) recordEvicted() {
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+	mp.evicted++
+}
+
+//reset clears the pending count/bytes accounting, used once
+//core.transactionPool has been flushed into a new self-event.
+func (mp *txMempool) reset() {
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+	mp.pendingTxs = 0
+	mp.pendingBytes = 0
+}
+
+func (mp *txMempool) stats() (pendingTxs, pendingBytes, rejected, evicted int) {
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+	return mp.pendingTxs, mp.pendingBytes, mp.rejected, mp.evicted
+}
@@ -2,6 +2,7 @@ package node
 
 import (
 	"crypto/ecdsa"
+	"encoding/json"
 	"fmt"
 	"sync"
 	"time"
@@ -30,11 +31,17 @@ type Node struct {
 	peerSelector PeerSelector
 	selectorLock sync.Mutex
 
+	announceCache *announceCache
+
+	health   *peerHealth
+	errorsCh chan peerError
+
 	trans net.Transport
 	netCh <-chan net.RPC
 
 	proxy    proxy.AppProxy
 	submitCh chan []byte
+	mempool  *txMempool
 
 	commitCh chan hg.Block
 
@@ -42,9 +49,10 @@ type Node struct {
 
 	controlTimer *ControlTimer
 
-	start        time.Time
-	syncRequests int
-	syncErrors   int
+	start         time.Time
+	syncStatsLock sync.Mutex
+	syncRequests  int
+	syncErrors    int
 }
 
 func NewNode(conf *Config,
@@ -62,23 +70,27 @@ func NewNode(conf *Config,
 	commitCh := make(chan hg.Block, 400)
 	core := NewCore(id, key, pmap, store, commitCh, conf.Logger)
 
-	peerSelector := NewRandomPeerSelector(participants, localAddr)
+	peerSelector := NewWeightedPeerSelector(participants, localAddr)
 
 	node := Node{
-		id:           id,
-		conf:         conf,
-		core:         &core,
-		localAddr:    localAddr,
-		logger:       conf.Logger.WithField("this_id", id),
-		peerSelector: peerSelector,
-		trans:        trans,
-		netCh:        trans.Consumer(),
-		proxy:        proxy,
-		submitCh:     proxy.SubmitCh(),
-		commitCh:     commitCh,
-		shutdownCh:   make(chan struct{}),
-		controlTimer: NewRandomControlTimer(conf.HeartbeatTimeout),
-		start:        time.Now(),
+		id:            id,
+		conf:          conf,
+		core:          &core,
+		localAddr:     localAddr,
+		logger:        conf.Logger.WithField("this_id", id),
+		peerSelector:  peerSelector,
+		announceCache: newAnnounceCache(),
+		health:        newPeerHealth(conf.MaxConsecutiveFailures, conf.QuarantineBaseBackoff, conf.MaxQuarantineBackoff),
+		errorsCh:      make(chan peerError, 100),
+		trans:         trans,
+		netCh:         trans.Consumer(),
+		proxy:         proxy,
+		submitCh:      proxy.SubmitCh(),
+		mempool:       newTxMempool(),
+		commitCh:      commitCh,
+		shutdownCh:    make(chan struct{}),
+		controlTimer:  NewRandomControlTimer(conf.HeartbeatTimeout),
+		start:         time.Now(),
 	}
 
 	//Initialize as Gossiping
@@ -119,7 +131,7 @@ func (n *Node) Run(gossip bool) {
 
 	//Execute Node State Machine
 	for {
-		// Run different routines depending on node state
+		//Run different routines depending on node state
 		state := n.getState()
 		n.logger.WithField("state", state.String()).Debug("Run loop")
 
@@ -127,7 +139,9 @@ func (n *Node) Run(gossip bool) {
 		case Gossiping:
 			n.lachesis(gossip)
 		case CatchingUp:
-			n.fastForward()
+			if err := n.fastForward(); err != nil {
+				n.logger.WithField("error", err).Error("fastForward()")
+			}
 		case Shutdown:
 			return
 		}
@@ -145,7 +159,9 @@ func (n *Node) doBackgroundWork() {
 			}
 		case t := <-n.submitCh:
 			n.logger.Debug("Adding Transaction")
-			n.addTransaction(t)
+			if err := n.addTransaction(t); err != nil {
+				n.logger.WithField("error", err).Debug("Rejecting Transaction")
+			}
 			if !n.controlTimer.set {
 				n.controlTimer.resetCh <- struct{}{}
 			}
@@ -158,12 +174,36 @@ func (n *Node) doBackgroundWork() {
 			if err := n.commit(block); err != nil {
 				n.logger.WithField("error", err).Error("Committing Block")
 			}
+		case pe := <-n.errorsCh:
+			n.handlePeerError(pe)
 		case <-n.shutdownCh:
 			return
 		}
 	}
 }
 
+//handlePeerError is fed by errorsCh whenever a background gossip goroutine
+//(via requestSync/requestEagerSync) observes a peer failing. It is the
+//only place that records peer failures in peerHealth, so background
+//goroutines never bump failure counters directly; successes are recorded
+//by requestSync/requestEagerSync themselves. The syncPool reports on its
+//own errorsCh, not this one.
+func (n *Node) handlePeerError(pe peerError) {
+	quarantined, backoff := n.health.recordFailure(pe.peer)
+	if quarantined {
+		n.logger.WithFields(logrus.Fields{
+			"peer":    pe.peer,
+			"backoff": backoff.String(),
+			"error":   pe.err,
+		}).Warn("Quarantining peer")
+	} else {
+		n.logger.WithFields(logrus.Fields{
+			"peer":  pe.peer,
+			"error": pe.err,
+		}).Debug("Peer failure")
+	}
+}
+
 func (n *Node) lachesis(gossip bool) {
 	for {
 		oldState := n.getState()
@@ -173,8 +213,8 @@ func (n *Node) lachesis(gossip bool) {
 				proceed, err := n.preGossip()
 				if proceed && err == nil {
 					n.logger.Debug("Time to gossip!")
-					peer := n.peerSelector.Next()
-					n.goFunc(func() { n.gossip(peer.NetAddr) })
+					peer := n.nextPeer()
+					n.goFunc(func() { n.maybeGossip(peer) })
 				}
 			}
 			if !n.core.NeedGossip() {
@@ -193,6 +233,40 @@ func (n *Node) lachesis(gossip bool) {
 	}
 }
 
+//nextPeer picks the next gossip target from the PeerSelector, skipping
+//any peer that is currently quarantined. If every peer is quarantined it
+//falls back to whichever one failed longest ago, so the node keeps
+//trying rather than stalling entirely.
+func (n *Node) nextPeer() net.Peer {
+	peers := n.peerSelector.Peers()
+	if len(peers) == 0 {
+		return net.Peer{}
+	}
+
+	var candidate net.Peer
+	for i := 0; i < len(peers); i++ {
+		n.selectorLock.Lock()
+		candidate = n.peerSelector.Next()
+		n.selectorLock.Unlock()
+		if !n.health.isQuarantined(candidate.NetAddr) {
+			return candidate
+		}
+	}
+
+	addrs := make([]string, len(peers))
+	for i, p := range peers {
+		addrs[i] = p.NetAddr
+	}
+	fallbackAddr := n.health.leastRecentlyFailed(addrs)
+	for _, p := range peers {
+		if p.NetAddr == fallbackAddr {
+			return p
+		}
+	}
+
+	return candidate
+}
+
 func (n *Node) processRPC(rpc net.RPC) {
 
 	if s := n.getState(); s != Gossiping {
@@ -211,6 +285,10 @@ func (n *Node) processRPC(rpc net.RPC) {
 		n.processSyncRequest(rpc, cmd)
 	case *net.EagerSyncRequest:
 		n.processEagerSyncRequest(rpc, cmd)
+	case *net.FastForwardRequest:
+		n.processFastForwardRequest(rpc, cmd)
+	case *net.AnnounceRequest:
+		n.processAnnounceRequest(rpc, cmd)
 	default:
 		n.logger.WithField("cmd", rpc.Command).Error("Unexpected RPC command")
 		rpc.Respond(nil, fmt.Errorf("unexpected command"))
@@ -297,6 +375,43 @@ func (n *Node) processEagerSyncRequest(rpc net.RPC, cmd *net.EagerSyncRequest) {
 	rpc.Respond(resp, err)
 }
 
+func (n *Node) processFastForwardRequest(rpc net.RPC, cmd *net.FastForwardRequest) {
+	n.logger.WithFields(logrus.Fields{
+		"from_id": cmd.FromID,
+		"index":   cmd.Index,
+	}).Debug("process FastForwardRequest")
+
+	n.coreLock.Lock()
+	lastBlockIndex := n.core.GetLastBlockIndex()
+	n.coreLock.Unlock()
+
+	resp := &net.FastForwardResponse{
+		FromID:         n.id,
+		LastBlockIndex: lastBlockIndex,
+	}
+
+	var respErr error
+
+	//Index < 0 is a status-only probe: the peer just wants our head, not a
+	//block, so the pool can pick a target to catch up to.
+	if cmd.Index >= 0 && cmd.Index <= lastBlockIndex {
+		n.coreLock.Lock()
+		block, err := n.core.hg.Store.GetBlock(cmd.Index)
+		if err == nil {
+			resp.Block = block
+			resp.Frame, err = n.core.hg.Store.GetFrame(block.RoundReceived())
+			resp.Roots = resp.Frame.Roots
+		}
+		n.coreLock.Unlock()
+		if err != nil {
+			n.logger.WithField("error", err).Error("Building FastForwardResponse")
+			respErr = err
+		}
+	}
+
+	rpc.Respond(resp, respErr)
+}
+
 func (n *Node) preGossip() (bool, error) {
 	n.coreLock.Lock()
 	defer n.coreLock.Unlock()
@@ -314,6 +429,12 @@ func (n *Node) preGossip() (bool, error) {
 		n.logger.WithField("error", err).Error("Adding SelfEvent")
 		return false, err
 	}
+	n.mempool.reset()
+
+	//Announce our new head to peers right away; their replies refresh our
+	//announce cache, so our next tick can skip a SyncRequest to a peer
+	//that has nothing new for us.
+	n.goFunc(n.broadcastAnnounce)
 
 	return true, nil
 }
@@ -361,6 +482,11 @@ func (n *Node) pull(peerAddr string) (syncLimit bool, otherKnownEvents map[int]i
 	resp, err := n.requestSync(peerAddr, knownEvents)
 	elapsed := time.Since(start)
 	n.logger.WithField("duration", elapsed.Nanoseconds()).Debug("requestSync()")
+
+	n.selectorLock.Lock()
+	n.peerSelector.RecordResult(peerAddr, elapsed, err, len(resp.Events), resp.SyncLimit)
+	n.selectorLock.Unlock()
+
 	if err != nil {
 		n.logger.WithField("error", err).Error("requestSync()")
 		return false, nil, err
@@ -376,6 +502,8 @@ func (n *Node) pull(peerAddr string) (syncLimit bool, otherKnownEvents map[int]i
 		return true, nil, nil
 	}
 
+	n.announceCache.record(peerAddr, resp.Known, hashKnown(resp.Known))
+
 	//Add Events to Hashgraph and create new Head if necessary
 	n.coreLock.Lock()
 	err = n.sync(resp.Events)
@@ -432,20 +560,114 @@ func (n *Node) push(peerAddr string, knownEvents map[int]int) error {
 		"success": resp2.Success,
 	}).Debug("EagerSyncResponse")
 
+	n.selectorLock.Lock()
+	if recorder, ok := n.peerSelector.(EagerSyncRecorder); ok {
+		recorder.RecordEagerSync(peerAddr, resp2.Success)
+	}
+	n.selectorLock.Unlock()
+
 	return nil
 }
 
 func (n *Node) fastForward() error {
 	n.logger.Debug("IN CATCHING-UP STATE")
-	n.logger.Debug("fast-sync not implemented yet")
 
-	//XXX Work in Progress on fsync branch
+	defer n.setState(Gossiping)
+
+	n.coreLock.Lock()
+	fromIndex := n.core.GetLastBlockIndex() + 1
+	n.coreLock.Unlock()
+
+	peers := n.peerSelector.Peers()
+	if len(peers) == 0 {
+		return fmt.Errorf("no peers to fast-forward from")
+	}
+
+	targetIndex := fromIndex - 1
+	for _, p := range peers {
+		_, _, _, lastBlockIndex, err := n.requestFastForward(p.NetAddr, -1)
+		if err != nil {
+			n.logger.WithFields(logrus.Fields{
+				"peer":  p.NetAddr,
+				"error": err,
+			}).Debug("Probing peer for FastForward target")
+			continue
+		}
+		if lastBlockIndex > targetIndex {
+			targetIndex = lastBlockIndex
+		}
+	}
+
+	if targetIndex < fromIndex {
+		n.logger.Debug("Nothing to fast-forward")
+		return nil
+	}
+
+	n.logger.WithFields(logrus.Fields{
+		"from":   fromIndex,
+		"target": targetIndex,
+	}).Info("FastForward")
 
-	n.setState(Gossiping)
+	pool := newSyncPool(n, peers, fromIndex, targetIndex)
+
+	lastDelivered, err := pool.run(n.applyFastForwardBlock)
+	if err != nil {
+		n.logger.WithFields(logrus.Fields{
+			"last_delivered": lastDelivered,
+			"error":          err,
+		}).Error("FastForward")
+		return err
+	}
+
+	n.logger.WithField("last_delivered", lastDelivered).Info("FastForward complete")
 
 	return nil
 }
 
+//applyFastForwardBlock validates a block fetched by the syncPool against
+//the known participant set, installs its frame's roots into the
+//hashgraph Store, and advances the core state to include it.
+func (n *Node) applyFastForwardBlock(block hg.Block, frame hg.Frame, roots map[string]hg.Root) error {
+	n.coreLock.Lock()
+	defer n.coreLock.Unlock()
+
+	participants, err := n.core.hg.Store.Participants()
+	if err != nil {
+		return err
+	}
+
+	if err := block.Verify(participants); err != nil {
+		return fmt.Errorf("verifying block %d: %s", block.Index(), err)
+	}
+
+	for p, root := range roots {
+		if err := n.core.hg.Store.SetRoot(p, root); err != nil {
+			return fmt.Errorf("installing root for %s: %s", p, err)
+		}
+	}
+
+	if err := n.core.FastForward(block, frame); err != nil {
+		return fmt.Errorf("fast-forwarding core to block %d: %s", block.Index(), err)
+	}
+
+	return nil
+}
+
+//requestFastForward asks a peer for the block at index (and its
+//associated frame/roots). An index of -1 is a status-only probe: the
+//peer responds with its LastBlockIndex but no block data.
+func (n *Node) requestFastForward(target string, index int) (hg.Block, hg.Frame, map[string]hg.Root, int, error) {
+	args := net.FastForwardRequest{
+		FromID: n.id,
+		Index:  index,
+	}
+
+	var out net.FastForwardResponse
+	err := n.trans.FastForward(target, &args, &out)
+
+	return out.Block, out.Frame, out.Roots, out.LastBlockIndex, err
+}
+
 func (n *Node) requestSync(target string, known map[int]int) (net.SyncResponse, error) {
 
 	args := net.SyncRequest{
@@ -454,7 +676,22 @@ func (n *Node) requestSync(target string, known map[int]int) (net.SyncResponse,
 	}
 
 	var out net.SyncResponse
+	start := time.Now()
 	err := n.trans.Sync(target, &args, &out)
+	elapsed := time.Since(start)
+
+	n.syncStatsLock.Lock()
+	n.syncRequests++
+	if err != nil {
+		n.syncErrors++
+	}
+	n.syncStatsLock.Unlock()
+
+	if err != nil {
+		n.reportPeerError(target, err)
+	} else {
+		n.health.recordSuccess(target, elapsed)
+	}
 
 	return out, err
 }
@@ -466,11 +703,32 @@ func (n *Node) requestEagerSync(target string, events []hg.WireEvent) (net.Eager
 	}
 
 	var out net.EagerSyncResponse
+	start := time.Now()
 	err := n.trans.EagerSync(target, &args, &out)
+	elapsed := time.Since(start)
+
+	if err != nil {
+		n.reportPeerError(target, err)
+	} else if !out.Success {
+		n.reportPeerError(target, fmt.Errorf("eager sync rejected"))
+	} else {
+		n.health.recordSuccess(target, elapsed)
+	}
 
 	return out, err
 }
 
+//reportPeerError hands a peer failure to the main loop via errorsCh
+//rather than mutating peerHealth directly, since requestSync and
+//requestEagerSync are called from background gossip goroutines.
+func (n *Node) reportPeerError(peer string, err error) {
+	select {
+	case n.errorsCh <- peerError{peer: peer, err: err}:
+	default:
+		n.logger.WithField("peer", peer).Warn("errorsCh full, dropping peer error")
+	}
+}
+
 func (n *Node) sync(events []hg.WireEvent) error {
 	//Insert Events in Hashgraph and create new Head if necessary
 	start := time.Now()
@@ -516,10 +774,33 @@ func (n *Node) commit(block hg.Block) error {
 	return err
 }
 
-func (n *Node) addTransaction(tx []byte) {
+//addTransaction admits tx into the mempool's accounting and the core
+//transaction pool as one critical section under coreLock, the same lock
+//preGossip holds across AddSelfEvent/mempool.reset(). Counting tryAdd
+//outside of coreLock would let a reset() land between the count and the
+//actual AddTransactions call, leaving a transaction sitting in the
+//freshly-flushed pool that the mempool's accounting never saw.
+func (n *Node) addTransaction(tx []byte) error {
+	if checker, ok := n.proxy.(txChecker); ok {
+		if err := checker.CheckTx(tx); err != nil {
+			n.mempool.recordEvicted()
+			return fmt.Errorf("CheckTx: %s", err)
+		}
+	}
+
 	n.coreLock.Lock()
 	defer n.coreLock.Unlock()
+
+	if err := n.mempool.tryAdd(tx); err != nil {
+		if reporter, ok := n.proxy.(mempoolFullReporter); ok {
+			reporter.SubmitError(tx, err)
+		}
+		return err
+	}
+
 	n.core.AddTransactions([][]byte{tx})
+
+	return nil
 }
 
 func (n *Node) Shutdown() {
@@ -586,6 +867,24 @@ func (n *Node) GetStats() map[string]string {
 		"id":                      strconv.Itoa(n.id),
 		"state":                   n.getState().String(),
 	}
+
+	if scored, ok := n.peerSelector.(ScoredPeerSelector); ok {
+		if raw, err := json.Marshal(scored.Scores()); err == nil {
+			s["peer_scores"] = string(raw)
+		}
+	}
+
+	s["quarantined_peers"] = strconv.Itoa(len(n.health.quarantinedPeers()))
+	if raw, err := json.Marshal(n.health.failureCounts()); err == nil {
+		s["peer_failure_counts"] = string(raw)
+	}
+
+	mempoolSize, mempoolBytes, mempoolRejected, mempoolEvicted := n.mempool.stats()
+	s["mempool_size"] = strconv.Itoa(mempoolSize)
+	s["mempool_bytes"] = strconv.Itoa(mempoolBytes)
+	s["mempool_rejected"] = strconv.Itoa(mempoolRejected)
+	s["mempool_evicted"] = strconv.Itoa(mempoolEvicted)
+
 	return s
 }
 
@@ -609,9 +908,13 @@ func (n *Node) logStats() {
 }
 
 func (n *Node) SyncRate() float64 {
+	n.syncStatsLock.Lock()
+	requests, errors := n.syncRequests, n.syncErrors
+	n.syncStatsLock.Unlock()
+
 	var syncErrorRate float64
-	if n.syncRequests != 0 {
-		syncErrorRate = float64(n.syncErrors) / float64(n.syncRequests)
+	if requests != 0 {
+		syncErrorRate = float64(errors) / float64(requests)
 	}
 	return 1 - syncErrorRate
 }
@@ -0,0 +1,166 @@
+package node
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+//maxBackoffExponent caps the exponent passed to math.Pow when computing a
+//quarantine backoff, so a peer that's been failing for a very long time
+//can't drive base * 2^exponent past the int64 range of time.Duration
+//(2^30 keeps it in range for any base up to 8s).
+const maxBackoffExponent = 30
+
+//peerHealthEntry tracks one peer's recent reliability.
+type peerHealthEntry struct {
+	consecutiveFailures int
+	lastFailure         time.Time
+	avgRTT              time.Duration
+	quarantineUntil     time.Time
+}
+
+//peerHealth is a per-peer failure/backoff tracker. A peer that racks up
+//maxConsecutiveFailures in a row is quarantined for a growing backoff,
+//rather than keeping its turn in the regular gossip rotation. The
+//thresholds are configurable (see Config) so operators can tune how
+//tolerant a deployment is of flaky peers.
+type peerHealth struct {
+	lock    sync.Mutex
+	entries map[string]*peerHealthEntry
+
+	maxConsecutiveFailures int
+	quarantineBaseBackoff  time.Duration
+	maxQuarantineBackoff   time.Duration
+}
+
+func newPeerHealth(maxConsecutiveFailures int, quarantineBaseBackoff, maxQuarantineBackoff time.Duration) *peerHealth {
+	return &peerHealth{
+		entries:                make(map[string]*peerHealthEntry),
+		maxConsecutiveFailures: maxConsecutiveFailures,
+		quarantineBaseBackoff:  quarantineBaseBackoff,
+		maxQuarantineBackoff:   maxQuarantineBackoff,
+	}
+}
+
+func (ph *peerHealth) entry(peer string) *peerHealthEntry {
+	e, ok := ph.entries[peer]
+	if !ok {
+		e = &peerHealthEntry{}
+		ph.entries[peer] = e
+	}
+	return e
+}
+
+//recordSuccess clears a peer's failure streak, lifts any quarantine, and
+//folds the latest RTT into its moving average.
+func (ph *peerHealth) recordSuccess(peer string, rtt time.Duration) {
+	ph.lock.Lock()
+	defer ph.lock.Unlock()
+
+	e := ph.entry(peer)
+	e.consecutiveFailures = 0
+	e.quarantineUntil = time.Time{}
+	if e.avgRTT == 0 {
+		e.avgRTT = rtt
+	} else {
+		e.avgRTT = (e.avgRTT + rtt) / 2
+	}
+}
+
+//recordFailure increments a peer's failure streak and, once it reaches
+//maxConsecutiveFailures, quarantines it for an exponentially growing
+//backoff. It reports whether this call (re)quarantined the peer, which is
+//true for every failure at or past the threshold, and the backoff applied.
+func (ph *peerHealth) recordFailure(peer string) (quarantined bool, backoff time.Duration) {
+	ph.lock.Lock()
+	defer ph.lock.Unlock()
+
+	e := ph.entry(peer)
+	e.consecutiveFailures++
+	e.lastFailure = time.Now()
+
+	if e.consecutiveFailures < ph.maxConsecutiveFailures {
+		return false, 0
+	}
+
+	//Clamp the exponent itself, not just the result: past about 33
+	//doublings of a 1s base the product exceeds the int64 range of
+	//time.Duration, and converting such a float to a time.Duration is
+	//implementation-defined (in practice a large negative value) which
+	//would sail past the backoff > maxQuarantineBackoff check below and
+	//instantly un-quarantine the peer.
+	exponent := e.consecutiveFailures - ph.maxConsecutiveFailures
+	if exponent > maxBackoffExponent {
+		exponent = maxBackoffExponent
+	}
+
+	backoff = time.Duration(float64(ph.quarantineBaseBackoff) * math.Pow(2, float64(exponent)))
+	if backoff > ph.maxQuarantineBackoff {
+		backoff = ph.maxQuarantineBackoff
+	}
+	e.quarantineUntil = time.Now().Add(backoff)
+
+	return true, backoff
+}
+
+func (ph *peerHealth) isQuarantined(peer string) bool {
+	ph.lock.Lock()
+	defer ph.lock.Unlock()
+
+	e, ok := ph.entries[peer]
+	if !ok {
+		return false
+	}
+	return time.Now().Before(e.quarantineUntil)
+}
+
+//leastRecentlyFailed returns whichever candidate's last failure is
+//oldest (or one that never failed), used as a fallback when every peer
+//is quarantined and gossip must still proceed.
+func (ph *peerHealth) leastRecentlyFailed(candidates []string) string {
+	ph.lock.Lock()
+	defer ph.lock.Unlock()
+
+	var best string
+	var bestTime time.Time
+	for _, c := range candidates {
+		e, ok := ph.entries[c]
+		if !ok {
+			return c
+		}
+		if best == "" || e.lastFailure.Before(bestTime) {
+			best = c
+			bestTime = e.lastFailure
+		}
+	}
+	return best
+}
+
+//quarantinedPeers lists peers currently under quarantine, for GetStats().
+func (ph *peerHealth) quarantinedPeers() []string {
+	ph.lock.Lock()
+	defer ph.lock.Unlock()
+
+	now := time.Now()
+	var q []string
+	for peer, e := range ph.entries {
+		if now.Before(e.quarantineUntil) {
+			q = append(q, peer)
+		}
+	}
+	return q
+}
+
+//failureCounts reports each peer's current consecutive-failure count,
+//for GetStats().
+func (ph *peerHealth) failureCounts() map[string]int {
+	ph.lock.Lock()
+	defer ph.lock.Unlock()
+
+	counts := make(map[string]int, len(ph.entries))
+	for peer, e := range ph.entries {
+		counts[peer] = e.consecutiveFailures
+	}
+	return counts
+}
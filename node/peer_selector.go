@@ -0,0 +1,258 @@
+package node
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Goplush/lachesisnode/m/net"
+)
+
+//PeerSelector picks the next peer to gossip with. Implementations may be
+//as simple as a random rotation or as elaborate as a scored/weighted
+//selection based on observed peer performance.
+type PeerSelector interface {
+	Peers() []net.Peer
+	UpdateLast(peer string)
+	Next() net.Peer
+	//RecordResult feeds the outcome of a pull round back into the
+	//selector. Implementations that don't score peers (e.g.
+	//RandomPeerSelector) may make this a no-op.
+	RecordResult(peer string, latency time.Duration, err error, numEvents int, syncLimit bool)
+}
+
+//ScoredPeerSelector is implemented by selectors that can report a
+//per-peer score, for GetStats() to surface to operators.
+type ScoredPeerSelector interface {
+	Scores() map[string]float64
+}
+
+//EagerSyncRecorder is implemented by selectors that track whether pushed
+//events were accepted, used to down-weight peers that keep rejecting them.
+type EagerSyncRecorder interface {
+	RecordEagerSync(peer string, accepted bool)
+}
+
+/*******************************************************************************
+RandomPeerSelector
+*******************************************************************************/
+
+//RandomPeerSelector picks the next peer at random, excluding the last one
+//that was used, so two gossip rounds in a row don't hit the same peer.
+type RandomPeerSelector struct {
+	peers     []net.Peer
+	localAddr string
+	last      string
+}
+
+func NewRandomPeerSelector(participants []net.Peer, localAddr string) *RandomPeerSelector {
+	_, peers := net.ExcludePeer(participants, localAddr)
+	return &RandomPeerSelector{
+		localAddr: localAddr,
+		peers:     peers,
+	}
+}
+
+func (ps *RandomPeerSelector) Peers() []net.Peer {
+	return ps.peers
+}
+
+func (ps *RandomPeerSelector) UpdateLast(peer string) {
+	ps.last = peer
+}
+
+func (ps *RandomPeerSelector) Next() net.Peer {
+	selectablePeers := ps.peers
+	if len(ps.peers) > 1 {
+		_, selectablePeers = net.ExcludePeer(ps.peers, ps.last)
+	}
+	i := rand.Intn(len(selectablePeers))
+	return selectablePeers[i]
+}
+
+//RecordResult is a no-op: RandomPeerSelector doesn't score peers.
+func (ps *RandomPeerSelector) RecordResult(peer string, latency time.Duration, err error, numEvents int, syncLimit bool) {
+}
+
+/*******************************************************************************
+WeightedPeerSelector
+*******************************************************************************/
+
+//minSelectionProbability is the floor every peer keeps regardless of its
+//score, so unproven peers still get picked occasionally and can build up
+//a track record.
+const minSelectionProbability = 0.05
+
+//peerStats holds the rolling statistics a WeightedPeerSelector uses to
+//score a peer. It is intentionally similar to the server-pool idea used
+//by light-client fetchers: latency and yield matter more than raw uptime.
+type peerStats struct {
+	syncCount      int
+	failureCount   int
+	totalLatency   time.Duration
+	totalEvents    int
+	syncLimitCount int
+	eagerSyncOK    int
+	eagerSyncTotal int
+}
+
+//score combines latency, yield and reliability into a single weight. It
+//favours low-latency, high-yield, reliable peers, but never returns
+//exactly zero so that every peer keeps a non-zero chance of being picked.
+func (s *peerStats) score() float64 {
+	if s.syncCount == 0 {
+		return minSelectionProbability
+	}
+
+	successRate := float64(s.syncCount-s.failureCount) / float64(s.syncCount)
+
+	avgLatency := s.totalLatency / time.Duration(s.syncCount)
+	latencyScore := 1.0
+	if avgLatency > 0 {
+		latencyScore = float64(time.Second) / float64(avgLatency+time.Second)
+	}
+
+	yieldScore := float64(s.totalEvents) / float64(s.syncCount)
+
+	limitPenalty := 1.0
+	if s.syncCount > 0 {
+		limitPenalty = 1.0 - (float64(s.syncLimitCount) / float64(s.syncCount))
+	}
+
+	eagerSyncScore := 1.0
+	if s.eagerSyncTotal > 0 {
+		eagerSyncScore = float64(s.eagerSyncOK) / float64(s.eagerSyncTotal)
+	}
+
+	raw := successRate * latencyScore * (1 + yieldScore) * limitPenalty * eagerSyncScore
+
+	return minSelectionProbability + raw
+}
+
+//WeightedPeerSelector chooses the next gossip target probabilistically,
+//favouring peers with a history of fast, successful, high-yield syncs,
+//while still giving unproven peers a chance to bootstrap their score.
+type WeightedPeerSelector struct {
+	peers     []net.Peer
+	localAddr string
+	last      string
+
+	statsLock sync.Mutex
+	stats     map[string]*peerStats
+}
+
+func NewWeightedPeerSelector(participants []net.Peer, localAddr string) *WeightedPeerSelector {
+	_, peers := net.ExcludePeer(participants, localAddr)
+
+	stats := make(map[string]*peerStats, len(peers))
+	for _, p := range peers {
+		stats[p.NetAddr] = &peerStats{}
+	}
+
+	return &WeightedPeerSelector{
+		localAddr: localAddr,
+		peers:     peers,
+		stats:     stats,
+	}
+}
+
+func (ps *WeightedPeerSelector) Peers() []net.Peer {
+	return ps.peers
+}
+
+func (ps *WeightedPeerSelector) UpdateLast(peer string) {
+	ps.last = peer
+}
+
+//Next picks a peer with probability proportional to its score, excluding
+//the peer we just gossiped with whenever there is an alternative.
+func (ps *WeightedPeerSelector) Next() net.Peer {
+	selectablePeers := ps.peers
+	if len(ps.peers) > 1 {
+		_, selectablePeers = net.ExcludePeer(ps.peers, ps.last)
+	}
+
+	ps.statsLock.Lock()
+	weights := make([]float64, len(selectablePeers))
+	total := 0.0
+	for i, p := range selectablePeers {
+		s, ok := ps.stats[p.NetAddr]
+		if !ok {
+			s = &peerStats{}
+			ps.stats[p.NetAddr] = s
+		}
+		weights[i] = s.score()
+		total += weights[i]
+	}
+	ps.statsLock.Unlock()
+
+	if total <= 0 {
+		return selectablePeers[rand.Intn(len(selectablePeers))]
+	}
+
+	pick := rand.Float64() * total
+	for i, w := range weights {
+		pick -= w
+		if pick <= 0 {
+			return selectablePeers[i]
+		}
+	}
+	return selectablePeers[len(selectablePeers)-1]
+}
+
+//RecordResult feeds the outcome of a pull round back into the selector
+//so future scoring reflects this peer's latest behaviour. Push outcomes
+//are fed separately through RecordEagerSync.
+func (ps *WeightedPeerSelector) RecordResult(peer string, latency time.Duration, err error, numEvents int, syncLimit bool) {
+	ps.statsLock.Lock()
+	defer ps.statsLock.Unlock()
+
+	s, ok := ps.stats[peer]
+	if !ok {
+		s = &peerStats{}
+		ps.stats[peer] = s
+	}
+
+	s.syncCount++
+	s.totalLatency += latency
+	if err != nil {
+		s.failureCount++
+		return
+	}
+	s.totalEvents += numEvents
+	if syncLimit {
+		s.syncLimitCount++
+	}
+}
+
+//RecordEagerSync feeds back whether a push (EagerSyncRequest) was
+//accepted by the peer, used to down-weight peers that keep rejecting our
+//pushes.
+func (ps *WeightedPeerSelector) RecordEagerSync(peer string, accepted bool) {
+	ps.statsLock.Lock()
+	defer ps.statsLock.Unlock()
+
+	s, ok := ps.stats[peer]
+	if !ok {
+		s = &peerStats{}
+		ps.stats[peer] = s
+	}
+
+	s.eagerSyncTotal++
+	if accepted {
+		s.eagerSyncOK++
+	}
+}
+
+//Scores returns a snapshot of each peer's current score, keyed by
+//NetAddr, for GetStats() to surface to operators.
+func (ps *WeightedPeerSelector) Scores() map[string]float64 {
+	ps.statsLock.Lock()
+	defer ps.statsLock.Unlock()
+
+	scores := make(map[string]float64, len(ps.stats))
+	for addr, s := range ps.stats {
+		scores[addr] = s.score()
+	}
+	return scores
+}
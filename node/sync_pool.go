@@ -0,0 +1,307 @@
+package node
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	hg "github.com/Goplush/lachesisnode/m/hashgraph"
+	"github.com/Goplush/lachesisnode/m/net"
+)
+
+const (
+	//fastForwardRequestTimeout is the longest a peer may take to answer a
+	//single FastForwardRequest: an answer that took longer is treated as an
+	//error. The wait itself is not cut short; it ends when the transport
+	//call returns.
+	fastForwardRequestTimeout = 15 * time.Second
+
+	//maxInFlightGap bounds how many block requests may be outstanding
+	//(requested but not yet answered) at once. It does not bound how far
+	//ahead of our current head we request: answered blocks waiting behind
+	//a missing one are buffered by run and do not count against it.
+	maxInFlightGap = 100
+
+	//minPeerBlockRate is the minimum number of blocks per second a peer
+	//must sustain, once it has answered a few requests, or it is dropped.
+	minPeerBlockRate = 1.0
+)
+
+//BlockRequest identifies a single committed block (and its associated
+//frame/roots) that the syncPool needs to fetch from some peer.
+type BlockRequest struct {
+	Index int
+}
+
+//peerError is reported on errorsCh whenever a peer times out, returns a
+//malformed response, or falls below minPeerBlockRate. The pool reassigns
+//any requests that were pending on that peer.
+type peerError struct {
+	peer string
+	err  error
+}
+
+//blockResult is what a peer-fetch goroutine hands back to the dispatcher
+//once a FastForwardResponse has been received.
+type blockResult struct {
+	peer  string
+	block hg.Block
+	frame hg.Frame
+	roots map[string]hg.Root
+}
+
+//peerProgress tracks what a peer has told us about its own chain, plus an
+//estimate of how many blocks per second it has delivered since the pool
+//started.
+type peerProgress struct {
+	lastBlockIndex int
+	delivered      int
+	since          time.Time
+}
+
+func (pp *peerProgress) rate() float64 {
+	elapsed := time.Since(pp.since).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(pp.delivered) / elapsed
+}
+
+//syncPool drives the CatchingUp state. A background goroutine pulls ranges
+//of committed blocks from multiple peers concurrently, keyed by
+//block.Index, and delivers them in order to fastForward() for validation
+//and application.
+type syncPool struct {
+	node   *Node
+	logger *logrus.Entry
+
+	peers        []net.Peer
+	progress     map[string]*peerProgress
+	progressLock sync.Mutex
+
+	requestsCh chan BlockRequest
+	resultsCh  chan blockResult
+	errorsCh   chan peerError
+
+	pending     map[int]string //block index -> peer it was requested from
+	pendingLock sync.Mutex
+
+	//dropCh holds one close-only channel per peer, closed by dropPeer to
+	//tell that peer's fetchPeer goroutine to exit. Guarded by progressLock
+	//since a peer is "dropped" exactly when it's removed from progress.
+	dropCh map[string]chan struct{}
+
+	nextIndex   int
+	targetIndex int
+
+	shutdownCh chan struct{}
+	stopOnce   sync.Once
+}
+
+func newSyncPool(n *Node, peers []net.Peer, fromIndex, targetIndex int) *syncPool {
+	progress := make(map[string]*peerProgress, len(peers))
+	dropCh := make(map[string]chan struct{}, len(peers))
+	for _, p := range peers {
+		progress[p.NetAddr] = &peerProgress{since: time.Now()}
+		dropCh[p.NetAddr] = make(chan struct{})
+	}
+
+	return &syncPool{
+		node:        n,
+		logger:      n.logger.WithField("component", "syncPool"),
+		peers:       peers,
+		progress:    progress,
+		dropCh:      dropCh,
+		requestsCh:  make(chan BlockRequest, maxInFlightGap),
+		resultsCh:   make(chan blockResult, maxInFlightGap),
+		errorsCh:    make(chan peerError, len(peers)+1),
+		pending:     make(map[int]string),
+		nextIndex:   fromIndex,
+		targetIndex: targetIndex,
+		shutdownCh:  make(chan struct{}),
+	}
+}
+
+//run starts the dispatcher and one fetch goroutine per peer, then blocks
+//delivering contiguous blocks in order to deliver until the pool catches up
+//with targetIndex or is stopped. It returns the index of the last block
+//that was successfully delivered.
+func (sp *syncPool) run(deliver func(hg.Block, hg.Frame, map[string]hg.Root) error) (int, error) {
+	//Every exit path must release the dispatcher and fetchPeer goroutines,
+	//or a validation/store failure from deliver() would otherwise leak
+	//them (and leave them hitting peers) forever.
+	defer sp.stop()
+
+	for _, p := range sp.peers {
+		sp.node.goFunc(func() { sp.fetchPeer(p) })
+	}
+	sp.node.goFunc(sp.dispatch)
+
+	buffer := make(map[int]blockResult)
+	lastDelivered := sp.nextIndex - 1
+
+	for lastDelivered < sp.targetIndex {
+		select {
+		case res := <-sp.resultsCh:
+			sp.pendingLock.Lock()
+			delete(sp.pending, res.block.Index())
+			sp.pendingLock.Unlock()
+
+			sp.progressLock.Lock()
+			if pp, ok := sp.progress[res.peer]; ok {
+				pp.delivered++
+			}
+			sp.progressLock.Unlock()
+
+			buffer[res.block.Index()] = res
+			for {
+				next, ok := buffer[lastDelivered+1]
+				if !ok {
+					break
+				}
+				if err := deliver(next.block, next.frame, next.roots); err != nil {
+					return lastDelivered, err
+				}
+				delete(buffer, lastDelivered+1)
+				lastDelivered++
+			}
+		case pe := <-sp.errorsCh:
+			sp.dropPeer(pe)
+		case <-sp.shutdownCh:
+			return lastDelivered, fmt.Errorf("syncPool stopped")
+		}
+	}
+
+	return lastDelivered, nil
+}
+
+//dispatch feeds requestsCh with every block index up to targetIndex,
+//pausing while maxInFlightGap requests are pending. Only unanswered
+//requests are counted, so the gap between our head and the highest
+//request is not bounded while an earlier block is still missing.
+func (sp *syncPool) dispatch() {
+	next := sp.nextIndex
+	for next <= sp.targetIndex {
+		sp.pendingLock.Lock()
+		inFlight := len(sp.pending)
+		sp.pendingLock.Unlock()
+
+		if inFlight >= maxInFlightGap {
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+
+		select {
+		case sp.requestsCh <- BlockRequest{Index: next}:
+			sp.pendingLock.Lock()
+			sp.pending[next] = ""
+			sp.pendingLock.Unlock()
+			next++
+		case <-sp.shutdownCh:
+			return
+		}
+	}
+}
+
+//fetchPeer pulls BlockRequests off requestsCh and serves them against a
+//single peer, reporting timeouts and slow peers on errorsCh.
+func (sp *syncPool) fetchPeer(peer net.Peer) {
+	dropCh := sp.dropCh[peer.NetAddr]
+
+	for {
+		select {
+		case <-dropCh:
+			return
+		case req := <-sp.requestsCh:
+			sp.pendingLock.Lock()
+			sp.pending[req.Index] = peer.NetAddr
+			sp.pendingLock.Unlock()
+
+			start := time.Now()
+			block, frame, roots, lastBlockIndex, err := sp.node.requestFastForward(peer.NetAddr, req.Index)
+			elapsed := time.Since(start)
+
+			if err != nil || elapsed > fastForwardRequestTimeout {
+				if err == nil {
+					err = fmt.Errorf("timed out after %s", elapsed)
+				}
+				sp.requeue(req)
+				sp.errorsCh <- peerError{peer: peer.NetAddr, err: err}
+				continue
+			}
+
+			//A peer answering with the wrong index (buggy or byzantine) must
+			//not be trusted: requeue the index we actually asked for, not
+			//whatever the block claims to be, or pending[req.Index] is never
+			//cleared and dispatch()'s in-flight cap never frees it up.
+			if block.Index() != req.Index {
+				sp.requeue(req)
+				sp.errorsCh <- peerError{peer: peer.NetAddr, err: fmt.Errorf("requested block %d, got %d", req.Index, block.Index())}
+				continue
+			}
+
+			sp.progressLock.Lock()
+			pp := sp.progress[peer.NetAddr]
+			if pp != nil {
+				pp.lastBlockIndex = lastBlockIndex
+			}
+			if pp != nil && pp.delivered > 2 && pp.rate() < minPeerBlockRate {
+				sp.progressLock.Unlock()
+				sp.requeue(req)
+				sp.errorsCh <- peerError{peer: peer.NetAddr, err: fmt.Errorf("rate below minimum")}
+				continue
+			}
+			sp.progressLock.Unlock()
+
+			sp.resultsCh <- blockResult{peer: peer.NetAddr, block: block, frame: frame, roots: roots}
+		case <-sp.shutdownCh:
+			return
+		}
+	}
+}
+
+//requeue puts a request back on requestsCh so another peer can pick it up.
+func (sp *syncPool) requeue(req BlockRequest) {
+	select {
+	case sp.requestsCh <- req:
+	case <-sp.shutdownCh:
+	}
+}
+
+//dropPeer removes a misbehaving peer from the pool and reassigns its
+//pending requests.
+func (sp *syncPool) dropPeer(pe peerError) {
+	sp.progressLock.Lock()
+	if _, alreadyDropped := sp.progress[pe.peer]; !alreadyDropped {
+		sp.progressLock.Unlock()
+		return
+	}
+	delete(sp.progress, pe.peer)
+	sp.progressLock.Unlock()
+
+	sp.logger.WithFields(logrus.Fields{
+		"peer":  pe.peer,
+		"error": pe.err,
+	}).Error("Dropping peer from syncPool")
+
+	//Tell this peer's fetchPeer goroutine to exit so it stops picking up
+	//future BlockRequests and can be rate-dropped only once.
+	if dropCh, ok := sp.dropCh[pe.peer]; ok {
+		close(dropCh)
+	}
+
+	sp.pendingLock.Lock()
+	for idx, p := range sp.pending {
+		if p == pe.peer {
+			delete(sp.pending, idx)
+			sp.requeue(BlockRequest{Index: idx})
+		}
+	}
+	sp.pendingLock.Unlock()
+}
+
+func (sp *syncPool) stop() {
+	sp.stopOnce.Do(func() { close(sp.shutdownCh) })
+}